@@ -0,0 +1,30 @@
+package main
+
+import "sync/atomic"
+
+// CatalogProvider holds the live route services behind an atomic pointer so
+// readers never see a partially-applied Reloader swap.
+type CatalogProvider struct {
+	catalog atomic.Pointer[[]RouteSvc]
+}
+
+// NewCatalogProvider seeds a CatalogProvider with already-prepared route services.
+func NewCatalogProvider(routeServices []RouteSvc) *CatalogProvider {
+	c := &CatalogProvider{}
+	c.Store(routeServices)
+	return c
+}
+
+// Store replaces the live catalog.
+func (c *CatalogProvider) Store(routeServices []RouteSvc) {
+	c.catalog.Store(&routeServices)
+}
+
+// Load returns the live catalog.
+func (c *CatalogProvider) Load() []RouteSvc {
+	routeServices := c.catalog.Load()
+	if routeServices == nil {
+		return nil
+	}
+	return *routeServices
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// newLogger picks its handler from LOG_FORMAT ("json" or "text") and level
+// from LOG_LEVEL, both defaulting to info/text.
+func newLogger() *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLogLevel(os.Getenv("LOG_LEVEL"))}
+
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	return slog.New(handler)
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// lagerAdapter bridges a *slog.Logger to the lager.Logger interface
+// brokerapi.New expects. There's no way to go the other direction: despite
+// what an earlier version of this comment claimed, lager has no NewHandler
+// that produces a slog.Handler, so a lager-based operator can't hand their
+// existing logger to slog.New() and get one of these for free.
+type lagerAdapter struct {
+	logger *slog.Logger
+}
+
+func newLagerAdapter(l *slog.Logger) *lagerAdapter {
+	return &lagerAdapter{logger: l}
+}
+
+func (a *lagerAdapter) RegisterSink(lager.Sink) {}
+
+func (a *lagerAdapter) SessionName() string { return "guard-broker" }
+
+func (a *lagerAdapter) Session(task string, data ...lager.Data) lager.Logger {
+	return a
+}
+
+func (a *lagerAdapter) WithData(data lager.Data) lager.Logger {
+	return a
+}
+
+func (a *lagerAdapter) Debug(action string, data ...lager.Data) {
+	a.logger.Debug(action, lagerDataArgs(data)...)
+}
+
+func (a *lagerAdapter) Info(action string, data ...lager.Data) {
+	a.logger.Info(action, lagerDataArgs(data)...)
+}
+
+func (a *lagerAdapter) Error(action string, err error, data ...lager.Data) {
+	args := lagerDataArgs(data)
+	if err != nil {
+		args = append(args, "error", err.Error())
+	}
+	a.logger.Error(action, args...)
+}
+
+func (a *lagerAdapter) Fatal(action string, err error, data ...lager.Data) {
+	a.Error(action, err, data...)
+	os.Exit(1)
+}
+
+func lagerDataArgs(data []lager.Data) []any {
+	args := make([]any, 0, len(data)*2)
+	for _, d := range data {
+		for k, v := range d {
+			args = append(args, k, v)
+		}
+	}
+	return args
+}
@@ -1,39 +1,65 @@
 package main
 
 import (
-	"code.cloudfoundry.org/lager"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/cloudfoundry-community/gautocloud"
 	"github.com/cloudfoundry-community/gautocloud/connectors/generic"
 	"github.com/cloudfoundry-community/gautocloud/logger"
-	"github.com/pivotal-cf/brokerapi"
-	"github.com/satori/go.uuid"
+	"github.com/google/uuid"
+	"github.com/pivotal-cf/brokerapi/v8"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 )
 
 const (
 	ROOT_UUID = "aaa4b55e-5768-41ea-a383-5f633725a88a"
+
+	routeSvcConfigFileEnv = "ROUTE_SVC_CONFIG_FILE"
+	cloudFileEnv          = "CLOUD_FILE"
 )
 
 func init() {
+	// gautocloud only ever reads CLOUD_FILE (cloudenv.LOCAL_ENV_KEY) to find
+	// its config file, and it decides whether it's "in" a cloud environment
+	// right here in RegisterConnector, before main() gets to parse flags. So
+	// ROUTE_SVC_CONFIG_FILE/--config and CLOUD_FILE need to agree before
+	// this call, or gautocloud.Inject and the fsnotify watch in reload.go end
+	// up reading two different files.
+	syncCloudFileEnv(os.Getenv(routeSvcConfigFileEnv))
 	gautocloud.RegisterConnector(generic.NewConfigGenericConnector(RouteSvcStaticConfig{}))
 }
 
+// syncCloudFileEnv points gautocloud at configFile by setting CLOUD_FILE,
+// unless the operator already set CLOUD_FILE to something else themselves.
+func syncCloudFileEnv(configFile string) {
+	if configFile == "" || os.Getenv(cloudFileEnv) != "" {
+		return
+	}
+	os.Setenv(cloudFileEnv, configFile)
+}
+
 type RouteSvcStaticConfig struct {
 	RouteServices  []RouteSvc `cloud:"route_services"`
 	BrokerUsername string     `cloud:"broker_username" cloud-default:"brokeruser"`
 	BrokerPassword string     `cloud:"broker_password" cloud-default:"password"`
 }
 type RouteSvcStaticBroker struct {
-	routeServices []RouteSvc
+	catalog *CatalogProvider
+	store   Store
+	logger  *slog.Logger
 }
 
-func NewRouteSvcStaticBroker(routeServices []RouteSvc) *RouteSvcStaticBroker {
-	return &RouteSvcStaticBroker{routeServices}
+// NewRouteSvcStaticBroker takes a Store so multi-replica deployments can
+// pass a shared backend instead of the default InMemoryStore.
+func NewRouteSvcStaticBroker(catalog *CatalogProvider, store Store, logger *slog.Logger) *RouteSvcStaticBroker {
+	return &RouteSvcStaticBroker{catalog, store, logger}
 }
 
 type RouteSvc struct {
@@ -64,14 +90,15 @@ func (r *RouteSvc) prepare() (RouteSvc, error) {
 			},
 		}
 	}
+	serviceUUID := uuid.NewSHA1(uuid.MustParse(ROOT_UUID), []byte(r.Name))
 	for i, plan := range r.Plans {
-		finalPlan, err := plan.prepare()
+		finalPlan, err := plan.prepare(serviceUUID)
 		if err != nil {
 			return RouteSvc{}, err
 		}
 		r.Plans[i] = finalPlan
 	}
-	r.Id = uuid.NewV3(uuid.FromStringOrNil(ROOT_UUID), r.Name).String()
+	r.Id = serviceUUID.String()
 
 	return *r, nil
 }
@@ -80,26 +107,39 @@ type Plan struct {
 	Name        string
 	Description string
 	Url         string
-	Id          string `cloud:"-"`
+	Urls        []PlanUrl `cloud:"urls"`
+	Schema      string    `cloud:"schema"`
+	Id          string    `cloud:"-"`
 }
 
-func (p *Plan) prepare() (Plan, error) {
-	if p.Url == "" {
+func (p *Plan) prepare(serviceUUID uuid.UUID) (Plan, error) {
+	if p.Url == "" && (p.Urls == nil || len(p.Urls) == 0) {
 		return Plan{}, fmt.Errorf("Plan '%s' must have an url", p.Name)
 	}
-	p.Id = uuid.NewV3(uuid.FromStringOrNil(ROOT_UUID), p.Name).String()
+	if p.Urls == nil || len(p.Urls) == 0 {
+		p.Urls = []PlanUrl{{Url: p.Url, Weight: 1}}
+	}
+	if p.Schema == "" {
+		for _, u := range p.Urls {
+			if hasTemplateDirectives(u.Url) {
+				return Plan{}, fmt.Errorf("Plan '%s' templates bind parameters into its url but has no schema; declare a schema to constrain what callers may bind with", p.Name)
+			}
+		}
+	}
+	p.Id = uuid.NewSHA1(serviceUUID, []byte(p.Name)).String()
 	return *p, nil
 }
 
-func (b *RouteSvcStaticBroker) findRouteUrl(serviceId, planId string) (string, error) {
+func (b *RouteSvcStaticBroker) findRouteUrl(serviceId, planId, bindingID string, rawParameters json.RawMessage) (string, error) {
 	var service RouteSvc
-	for _, svc := range b.routeServices {
+	for _, svc := range b.catalog.Load() {
 		if svc.Id == serviceId {
 			service = svc
 			break
 		}
 	}
 	if service.Id == "" {
+		b.logger.Error("plan-lookup-failed", "service_id", serviceId, "plan_id", planId, "reason", "service not found")
 		return "", fmt.Errorf("Service with id %s can't be found", serviceId)
 	}
 	var plan Plan
@@ -110,13 +150,19 @@ func (b *RouteSvcStaticBroker) findRouteUrl(serviceId, planId string) (string, e
 		}
 	}
 	if plan.Id == "" {
+		b.logger.Error("plan-lookup-failed", "service_id", serviceId, "plan_id", planId, "reason", "plan not found")
 		return "", fmt.Errorf("Plan with id %s can't be found in service %s ", planId, serviceId)
 	}
-	return plan.Url, nil
+	if err := validateBindParameters(plan.Schema, rawParameters); err != nil {
+		return "", err
+	}
+	url := selectPlanUrl(plan.Urls, bindingID)
+	return renderUrl(url, rawParameters)
 }
-func (b *RouteSvcStaticBroker) Services(context.Context) []brokerapi.Service {
+
+func (b *RouteSvcStaticBroker) Services(context.Context) ([]brokerapi.Service, error) {
 	services := make([]brokerapi.Service, 0)
-	for _, routeSvc := range b.routeServices {
+	for _, routeSvc := range b.catalog.Load() {
 		plans := make([]brokerapi.ServicePlan, 0)
 		for _, plan := range routeSvc.Plans {
 			plans = append(plans, brokerapi.ServicePlan{
@@ -136,36 +182,124 @@ func (b *RouteSvcStaticBroker) Services(context.Context) []brokerapi.Service {
 			Plans:         plans,
 		})
 	}
-	return services
+	return services, nil
 }
 
 func (b *RouteSvcStaticBroker) Provision(context context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+	err := b.store.SaveInstance(InstanceRecord{
+		InstanceID: instanceID,
+		ServiceID:  details.ServiceID,
+		PlanID:     details.PlanID,
+	})
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusInternalServerError, "internal-server-error",
+		).WithEmptyResponse().Build()
+	}
 	return brokerapi.ProvisionedServiceSpec{}, nil
 }
 
 func (b *RouteSvcStaticBroker) Deprovision(context context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
+	if err := b.store.DeleteInstance(instanceID); err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusInternalServerError, "internal-server-error",
+		).WithEmptyResponse().Build()
+	}
 	return brokerapi.DeprovisionServiceSpec{}, nil
 }
 
-func (b *RouteSvcStaticBroker) Bind(context context.Context, instanceID string, bindingID string, details brokerapi.BindDetails) (brokerapi.Binding, error) {
-	url, err := b.findRouteUrl(details.ServiceID, details.PlanID)
+func (b *RouteSvcStaticBroker) GetInstance(context context.Context, instanceID string, details domain.FetchInstanceDetails) (brokerapi.GetInstanceDetailsSpec, error) {
+	record, err := b.store.GetInstance(instanceID)
+	if err != nil {
+		return brokerapi.GetInstanceDetailsSpec{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusNotFound, "instance-not-found",
+		).WithEmptyResponse().Build()
+	}
+	return brokerapi.GetInstanceDetailsSpec{
+		ServiceID: record.ServiceID,
+		PlanID:    record.PlanID,
+	}, nil
+}
+
+func (b *RouteSvcStaticBroker) Bind(context context.Context, instanceID string, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (brokerapi.Binding, error) {
+	b.logger.Info("bind-attempt", "service_id", details.ServiceID, "plan_id", details.PlanID, "instance_id", instanceID, "binding_id", bindingID)
+	url, err := b.findRouteUrl(details.ServiceID, details.PlanID, bindingID, details.RawParameters)
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return brokerapi.Binding{}, brokerapi.NewFailureResponseBuilder(
+				err, http.StatusBadRequest, "invalid-bind-parameters",
+			).WithEmptyResponse().Build()
+		}
+		return brokerapi.Binding{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusInternalServerError, "internal-server-error",
+		).WithEmptyResponse().Build()
+	}
+	err = b.store.SaveBinding(BindingRecord{
+		BindingID:       bindingID,
+		InstanceID:      instanceID,
+		ServiceID:       details.ServiceID,
+		PlanID:          details.PlanID,
+		RouteServiceURL: url,
+	})
 	if err != nil {
 		return brokerapi.Binding{}, brokerapi.NewFailureResponseBuilder(
 			err, http.StatusInternalServerError, "internal-server-error",
 		).WithEmptyResponse().Build()
 	}
+	recordBindingsGauge(b.store)
 	return brokerapi.Binding{
 		Credentials:     "",
 		RouteServiceURL: url,
 	}, nil
 }
 
-func (b *RouteSvcStaticBroker) Unbind(context context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) error {
-	return nil
+func (b *RouteSvcStaticBroker) GetBinding(context context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (brokerapi.GetBindingSpec, error) {
+	record, err := b.store.GetBinding(instanceID, bindingID)
+	if err != nil {
+		return brokerapi.GetBindingSpec{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusNotFound, "binding-not-found",
+		).WithEmptyResponse().Build()
+	}
+	return brokerapi.GetBindingSpec{
+		Credentials:     "",
+		RouteServiceURL: record.RouteServiceURL,
+	}, nil
 }
 
-func (b *RouteSvcStaticBroker) LastOperation(context context.Context, instanceID, operationData string) (brokerapi.LastOperation, error) {
-	return brokerapi.LastOperation{}, nil
+func (b *RouteSvcStaticBroker) Unbind(context context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (brokerapi.UnbindSpec, error) {
+	err := b.store.DeleteBinding(instanceID, bindingID)
+	recordBindingsGauge(b.store)
+	if err != nil {
+		return brokerapi.UnbindSpec{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusInternalServerError, "internal-server-error",
+		).WithEmptyResponse().Build()
+	}
+	return brokerapi.UnbindSpec{}, nil
+}
+
+func (b *RouteSvcStaticBroker) LastOperation(context context.Context, instanceID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	if _, err := b.store.GetInstance(instanceID); err != nil {
+		return brokerapi.LastOperation{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusNotFound, "instance-not-found",
+		).WithEmptyResponse().Build()
+	}
+	return brokerapi.LastOperation{
+		State:       brokerapi.Succeeded,
+		Description: "route service instance is active",
+	}, nil
+}
+
+func (b *RouteSvcStaticBroker) LastBindingOperation(context context.Context, instanceID, bindingID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	if _, err := b.store.GetBinding(instanceID, bindingID); err != nil {
+		return brokerapi.LastOperation{}, brokerapi.NewFailureResponseBuilder(
+			err, http.StatusNotFound, "binding-not-found",
+		).WithEmptyResponse().Build()
+	}
+	return brokerapi.LastOperation{
+		State:       brokerapi.Succeeded,
+		Description: "route service binding is active",
+	}, nil
 }
 
 func (b *RouteSvcStaticBroker) Update(context context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
@@ -174,40 +308,56 @@ func (b *RouteSvcStaticBroker) Update(context context.Context, instanceID string
 
 func main() {
 	debugInit := flag.Bool("debug-init", false, "enable init delog logs")
+	configFile := flag.String("config", os.Getenv(routeSvcConfigFileEnv), "path to a mounted config file to watch for catalog reloads; "+
+		"set via "+routeSvcConfigFileEnv+" instead of this flag so gautocloud reads the same file (see syncCloudFileEnv)")
 	flag.Parse()
 
-	conf := &RouteSvcStaticConfig{}
 	if *debugInit {
 		gautocloud.SetLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime), logger.Ldebug)
 	}
 
-	err := gautocloud.Inject(conf)
+	appLogger := newLogger()
+	if *configFile != "" && *configFile != os.Getenv(cloudFileEnv) {
+		appLogger.Warn("config-file-not-synced", "config_file", *configFile, "cloud_file_env", os.Getenv(cloudFileEnv),
+			"hint", "set "+routeSvcConfigFileEnv+" instead of --config so gautocloud reloads from the watched file")
+	}
+	routeServices, conf, err := loadRouteServices()
 	if err != nil {
+		appLogger.Error("config-validation-failed", "error", err.Error())
 		panic(err)
 	}
-	if conf.RouteServices == nil || len(conf.RouteServices) == 0 {
-		panic(fmt.Errorf("You must have configured route service in your cloud configuration."))
-	}
-	for i, routeSvc := range conf.RouteServices {
-		finalRouteSvc, err := routeSvc.prepare()
-		if err != nil {
-			panic(fmt.Errorf("Error on route number %d: %s", i, err.Error()))
-		}
-		conf.RouteServices[i] = finalRouteSvc
-	}
-	serviceBroker := NewRouteSvcStaticBroker(conf.RouteServices)
-	logger := lager.NewLogger("guard-broker")
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.ERROR))
+	readyState.Store(true)
+	catalog := NewCatalogProvider(routeServices)
+	reloader := NewReloader(catalog, appLogger, *configFile)
+	reloader.Watch()
+
+	serviceBroker := NewRouteSvcStaticBroker(catalog, NewInMemoryStore(), appLogger)
 	credentials := brokerapi.BrokerCredentials{
 		Username: conf.BrokerUsername,
 		Password: conf.BrokerPassword,
 	}
-	brokerAPI := brokerapi.New(serviceBroker, logger, credentials)
-	http.Handle("/", brokerAPI)
+	brokerAPI := brokerapi.New(serviceBroker, newLagerAdapter(appLogger), credentials)
+
 	port := "8080"
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
 	}
-	http.ListenAndServe(":"+port, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", metricsMiddleware(brokerAPI, catalog))
+
+	adminPort := os.Getenv("ADMIN_PORT")
+	if adminPort == "" || adminPort == port {
+		adminMux := newAdminMux()
+		mux.Handle("/metrics", adminMux)
+		mux.Handle("/healthz", adminMux)
+		mux.Handle("/readyz", adminMux)
+	} else {
+		go func() {
+			if err := http.ListenAndServe(":"+adminPort, newAdminMux()); err != nil {
+				appLogger.Error("admin-server-failed", "error", err.Error())
+			}
+		}()
+	}
+	http.ListenAndServe(":"+port, mux)
 }
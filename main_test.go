@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRouteSvcPrepareIsDeterministic(t *testing.T) {
+	svc := RouteSvc{
+		Name: "my-route-service",
+		Url:  "https://gateway.example.com",
+	}
+	first, err := svc.prepare()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	svc2 := RouteSvc{
+		Name: "my-route-service",
+		Url:  "https://gateway.example.com",
+	}
+	second, err := svc2.prepare()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if first.Id != second.Id {
+		t.Fatalf("expected stable service id across restarts, got %s and %s", first.Id, second.Id)
+	}
+	if first.Plans[0].Id != second.Plans[0].Id {
+		t.Fatalf("expected stable plan id across restarts, got %s and %s", first.Plans[0].Id, second.Plans[0].Id)
+	}
+}
+
+func TestPlanIdsDoNotCollideAcrossServices(t *testing.T) {
+	svcA := RouteSvc{
+		Name:  "service-a",
+		Url:   "https://a.example.com",
+		Plans: []Plan{{Name: "shared", Url: "https://a.example.com"}},
+	}
+	svcB := RouteSvc{
+		Name:  "service-b",
+		Url:   "https://b.example.com",
+		Plans: []Plan{{Name: "shared", Url: "https://b.example.com"}},
+	}
+
+	preparedA, err := svcA.prepare()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	preparedB, err := svcB.prepare()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if preparedA.Plans[0].Id == preparedB.Plans[0].Id {
+		t.Fatalf("expected plan ids to be namespaced per service, both resolved to %s", preparedA.Plans[0].Id)
+	}
+}
+
+func TestSelectPlanUrlIsStablePerBinding(t *testing.T) {
+	urls := []PlanUrl{{Url: "https://a.example.com"}, {Url: "https://b.example.com"}}
+
+	first := selectPlanUrl(urls, "binding-1")
+	second := selectPlanUrl(urls, "binding-1")
+	if first != second {
+		t.Fatalf("expected the same binding to always resolve to the same url, got %s and %s", first, second)
+	}
+}
+
+func TestSelectPlanUrlRespectsWeights(t *testing.T) {
+	urls := []PlanUrl{{Url: "https://a.example.com", Weight: 1}, {Url: "https://b.example.com", Weight: 99}}
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		bindingID := "binding-" + string(rune(i))
+		counts[selectPlanUrl(urls, bindingID)]++
+	}
+	if counts["https://b.example.com"] <= counts["https://a.example.com"] {
+		t.Fatalf("expected the heavily weighted url to be selected more often, got %v", counts)
+	}
+}
+
+func TestSelectPlanUrlSingleUrlShortCircuits(t *testing.T) {
+	urls := []PlanUrl{{Url: "https://only.example.com"}}
+	if got := selectPlanUrl(urls, "any-binding"); got != "https://only.example.com" {
+		t.Fatalf("expected the sole url to be returned unconditionally, got %s", got)
+	}
+}
+
+func TestRenderUrlInterpolatesBindParameters(t *testing.T) {
+	rendered, err := renderUrl("https://gateway.example.com/{{.tenant}}/proxy", json.RawMessage(`{"tenant":"acme"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rendered != "https://gateway.example.com/acme/proxy" {
+		t.Fatalf("expected tenant to be interpolated, got %s", rendered)
+	}
+}
+
+func TestRenderUrlMissingKeyIsValidationError(t *testing.T) {
+	_, err := renderUrl("https://gateway.example.com/{{.tenant}}/proxy", json.RawMessage(`{}`))
+	if err == nil {
+		t.Fatal("expected an error for a missing template key")
+	}
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("expected a *ValidationError so bind maps it to an OSB 400, got %T", err)
+	}
+}
+
+func TestValidateBindParametersAcceptsAnythingWithoutSchema(t *testing.T) {
+	if err := validateBindParameters("", json.RawMessage(`{"anything":"goes"}`)); err != nil {
+		t.Fatalf("expected an empty schema to accept any parameters, got %s", err)
+	}
+}
+
+func TestValidateBindParametersRejectsSchemaViolations(t *testing.T) {
+	schema := `{"type":"object","required":["tenant"],"properties":{"tenant":{"type":"string"}}}`
+	if err := validateBindParameters(schema, json.RawMessage(`{}`)); err == nil {
+		t.Fatal("expected missing required property to fail validation")
+	} else if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("expected a *ValidationError so bind maps it to an OSB 400, got %T", err)
+	}
+
+	if err := validateBindParameters(schema, json.RawMessage(`{"tenant":"acme"}`)); err != nil {
+		t.Fatalf("expected matching parameters to pass validation, got %s", err)
+	}
+}
+
+func TestPlanPrepareRejectsTemplatedUrlWithoutSchema(t *testing.T) {
+	plan := Plan{Name: "templated", Url: "https://gateway.example.com/{{.tenant}}/proxy"}
+	_, err := plan.prepare(uuid.NewSHA1(uuid.MustParse(ROOT_UUID), []byte("svc")))
+	if err == nil {
+		t.Fatal("expected a templated url with no schema to be rejected")
+	}
+	if !strings.Contains(err.Error(), "schema") {
+		t.Fatalf("expected the error to call out the missing schema, got %s", err)
+	}
+}
+
+func TestPlanPrepareAllowsTemplatedUrlWithSchema(t *testing.T) {
+	plan := Plan{
+		Name:   "templated",
+		Url:    "https://gateway.example.com/{{.tenant}}/proxy",
+		Schema: `{"type":"object","required":["tenant"]}`,
+	}
+	if _, err := plan.prepare(uuid.NewSHA1(uuid.MustParse(ROOT_UUID), []byte("svc"))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestRenderUrlEscapesPathTraversalAndQueryInjection(t *testing.T) {
+	rendered, err := renderUrl("https://gateway.example.com/{{.tenant}}/proxy", json.RawMessage(`{"tenant":"../../admin?x=1#frag"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(rendered, "/admin") || strings.ContainsAny(rendered, "?#") {
+		t.Fatalf("expected the tenant value to be escaped into a single path segment, got %s", rendered)
+	}
+}
+
+func TestReloadSwapsCatalogOnSuccess(t *testing.T) {
+	catalog := NewCatalogProvider([]RouteSvc{{Name: "old"}})
+	reloader := NewReloader(catalog, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	reloader.load = func() ([]RouteSvc, RouteSvcStaticConfig, error) {
+		return []RouteSvc{{Name: "new"}}, RouteSvcStaticConfig{}, nil
+	}
+
+	reloader.Reload()
+
+	loaded := catalog.Load()
+	if len(loaded) != 1 || loaded[0].Name != "new" {
+		t.Fatalf("expected the catalog to be swapped to the reloaded route services, got %v", loaded)
+	}
+}
+
+func TestReloadKeepsPreviousCatalogOnError(t *testing.T) {
+	catalog := NewCatalogProvider([]RouteSvc{{Name: "old"}})
+	reloader := NewReloader(catalog, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	reloader.load = func() ([]RouteSvc, RouteSvcStaticConfig, error) {
+		return nil, RouteSvcStaticConfig{}, fmt.Errorf("boom")
+	}
+
+	reloader.Reload()
+
+	loaded := catalog.Load()
+	if len(loaded) != 1 || loaded[0].Name != "old" {
+		t.Fatalf("expected a failed reload to leave the previous catalog live, got %v", loaded)
+	}
+}
+
+func TestNewRouteSvcStaticBrokerUsesGivenStore(t *testing.T) {
+	store := NewInMemoryStore()
+	broker := NewRouteSvcStaticBroker(NewCatalogProvider(nil), store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if broker.store != store {
+		t.Fatal("expected NewRouteSvcStaticBroker to use the given store instance")
+	}
+}
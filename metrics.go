@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "route_service_broker_requests_total",
+		Help: "Total OSB requests handled by the broker, labeled by verb, service, plan and HTTP status.",
+	}, []string{"verb", "service", "plan", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "route_service_broker_request_duration_seconds",
+		Help: "OSB request latency in seconds, labeled by verb.",
+	}, []string{"verb"})
+
+	bindingsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "route_service_broker_bindings",
+		Help: "Number of route service bindings currently known to the broker.",
+	})
+)
+
+// recordBindingsGauge refreshes the bindings gauge from store, leaving it
+// unchanged on error.
+func recordBindingsGauge(store Store) {
+	if count, err := store.CountBindings(); err == nil {
+		bindingsGauge.Set(float64(count))
+	}
+}
+
+// osbVerb classifies an incoming request into one of the OSB verbs the
+// metrics are labeled by, based on its method and path shape.
+func osbVerb(method, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	// v2/service_instances/:id[/service_bindings/:binding_id][/last_operation]
+	switch {
+	case len(segments) >= 1 && segments[len(segments)-1] == "catalog":
+		return "services"
+	case strings.Contains(path, "last_operation"):
+		return "last_operation"
+	case strings.Contains(path, "service_bindings"):
+		switch method {
+		case http.MethodPut:
+			return "bind"
+		case http.MethodDelete:
+			return "unbind"
+		case http.MethodGet:
+			return "get_binding"
+		}
+	case strings.Contains(path, "service_instances"):
+		switch method {
+		case http.MethodPut:
+			return "provision"
+		case http.MethodDelete:
+			return "deprovision"
+		case http.MethodGet:
+			return "get_instance"
+		case http.MethodPatch:
+			return "update"
+		}
+	}
+	return "unknown"
+}
+
+// requestServiceAndPlanIDs returns the service_id/plan_id OSB attaches to r,
+// falling back to peeking the JSON body (and restoring it) for verbs that
+// carry them there instead of as query parameters.
+func requestServiceAndPlanIDs(r *http.Request) (string, string) {
+	serviceID := r.URL.Query().Get("service_id")
+	planID := r.URL.Query().Get("plan_id")
+	if serviceID != "" || r.Body == nil {
+		return serviceID, planID
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return serviceID, planID
+	}
+
+	var details struct {
+		ServiceID string `json:"service_id"`
+		PlanID    string `json:"plan_id"`
+	}
+	if err := json.Unmarshal(body, &details); err == nil {
+		serviceID, planID = details.ServiceID, details.PlanID
+	}
+	return serviceID, planID
+}
+
+// resolveNames turns a service_id/plan_id pair into their human-readable
+// catalog names, falling back to "unknown" when absent or not found.
+func resolveNames(catalog *CatalogProvider, serviceID, planID string) (string, string) {
+	serviceName, planName := "unknown", "unknown"
+	if serviceID == "" {
+		return serviceName, planName
+	}
+	for _, svc := range catalog.Load() {
+		if svc.Id != serviceID {
+			continue
+		}
+		serviceName = svc.Name
+		for _, plan := range svc.Plans {
+			if plan.Id == planID {
+				planName = plan.Name
+				break
+			}
+		}
+		break
+	}
+	return serviceName, planName
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// metricsMiddleware records request counts, durations and status codes for
+// every OSB call handled by next.
+func metricsMiddleware(next http.Handler, catalog *CatalogProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		serviceID, planID := requestServiceAndPlanIDs(r)
+
+		next.ServeHTTP(recorder, r)
+
+		verb := osbVerb(r.Method, r.URL.Path)
+		serviceName, planName := resolveNames(catalog, serviceID, planID)
+		requestsTotal.WithLabelValues(verb, serviceName, planName, strconv.Itoa(recorder.status)).Inc()
+		requestDuration.WithLabelValues(verb).Observe(time.Since(start).Seconds())
+	})
+}
+
+// readyState reports whether the broker has loaded a valid catalog at least
+// once, for /readyz.
+var readyState atomic.Bool
+
+// newAdminMux builds the admin handler serving /metrics, /healthz and
+// /readyz, served on ADMIN_PORT (or the main port, when unset).
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !readyState.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("config not loaded"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cloudfoundry-community/gautocloud"
+	"github.com/fsnotify/fsnotify"
+)
+
+// loadRouteServices is shared by the initial boot and every later reload,
+// so both go through identical validation. gautocloud.Inject only reads its
+// loader's in-memory store, populated once at RegisterConnector time, so
+// ReloadConnectors has to run first or every later call here would just
+// keep returning the config loaded at boot.
+func loadRouteServices() ([]RouteSvc, RouteSvcStaticConfig, error) {
+	gautocloud.ReloadConnectors()
+	conf := RouteSvcStaticConfig{}
+	if err := gautocloud.Inject(&conf); err != nil {
+		return nil, conf, err
+	}
+	if conf.RouteServices == nil || len(conf.RouteServices) == 0 {
+		return nil, conf, fmt.Errorf("You must have configured route service in your cloud configuration.")
+	}
+	for i, routeSvc := range conf.RouteServices {
+		finalRouteSvc, err := routeSvc.prepare()
+		if err != nil {
+			return nil, conf, fmt.Errorf("Error on route number %d: %s", i, err.Error())
+		}
+		conf.RouteServices[i] = finalRouteSvc
+	}
+	return conf.RouteServices, conf, nil
+}
+
+// Reloader re-runs loadRouteServices on SIGHUP or when the watched config
+// file changes, and atomically swaps the result into a CatalogProvider. A
+// failed reload is logged and discarded, leaving the previous catalog live.
+type Reloader struct {
+	catalog    *CatalogProvider
+	logger     *slog.Logger
+	configFile string
+	load       func() ([]RouteSvc, RouteSvcStaticConfig, error)
+}
+
+// NewReloader creates a Reloader. configFile may be empty, in which case
+// only SIGHUP triggers a reload.
+func NewReloader(catalog *CatalogProvider, logger *slog.Logger, configFile string) *Reloader {
+	return &Reloader{catalog: catalog, logger: logger, configFile: configFile, load: loadRouteServices}
+}
+
+// Reload runs one validation+swap pass.
+func (r *Reloader) Reload() {
+	routeServices, _, err := r.load()
+	if err != nil {
+		r.logger.Error("catalog-reload-failed", "error", err.Error())
+		return
+	}
+	r.catalog.Store(routeServices)
+	r.logger.Info("catalog-reloaded", "route_service_count", len(routeServices))
+}
+
+// Watch starts the SIGHUP and, if configFile is set, fsnotify listeners in
+// background goroutines. It returns immediately.
+func (r *Reloader) Watch() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			r.logger.Info("catalog-reload-triggered", "trigger", "sighup")
+			r.Reload()
+		}
+	}()
+
+	if r.configFile == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		r.logger.Error("catalog-watch-setup-failed", "error", err.Error())
+		return
+	}
+	configDir := filepath.Dir(r.configFile)
+	if err := watcher.Add(configDir); err != nil {
+		r.logger.Error("catalog-watch-setup-failed", "error", err.Error())
+		return
+	}
+	// Kubernetes mounts a ConfigMap by pointing a "..data" symlink in
+	// configDir at a new timestamped directory and atomically repointing it
+	// on update, so the event we see names "..data" (or the configured file
+	// itself, for a plain bind-mounted file), never an intermediate state.
+	dataSymlink := filepath.Join(configDir, "..data")
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Clean(event.Name)
+				if name != filepath.Clean(r.configFile) && name != filepath.Clean(dataSymlink) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				r.logger.Info("catalog-reload-triggered", "trigger", "config-file-change")
+				r.Reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Error("catalog-watch-error", "error", err.Error())
+			}
+		}
+	}()
+}
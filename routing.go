@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// PlanUrl is one upstream candidate for a plan. Weight controls how often
+// it's chosen relative to the others; a zero Weight defaults to 1.
+type PlanUrl struct {
+	Url    string
+	Weight int
+}
+
+// ValidationError marks a bind-time parameter failure; Bind maps it to an
+// OSB 400 instead of a 500.
+type ValidationError struct {
+	err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// selectPlanUrl picks a url out of urls by weighted hashing on bindingID, so
+// the same binding always resolves to the same url for a given set of urls
+// and weights. The hash is taken modulo the total weight, so adding,
+// removing or reweighting a url can move most bindings to a different url.
+func selectPlanUrl(urls []PlanUrl, bindingID string) string {
+	if len(urls) == 1 {
+		return urls[0].Url
+	}
+
+	total := 0
+	for _, u := range urls {
+		total += urlWeight(u)
+	}
+
+	h := sha1.Sum([]byte(bindingID))
+	point := int(binary.BigEndian.Uint32(h[:4])) % total
+	if point < 0 {
+		point += total
+	}
+
+	sorted := make([]PlanUrl, len(urls))
+	copy(sorted, urls)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Url < sorted[j].Url })
+
+	cumulative := 0
+	for _, u := range sorted {
+		cumulative += urlWeight(u)
+		if point < cumulative {
+			return u.Url
+		}
+	}
+	return sorted[len(sorted)-1].Url
+}
+
+func urlWeight(u PlanUrl) int {
+	if u.Weight <= 0 {
+		return 1
+	}
+	return u.Weight
+}
+
+// hasTemplateDirectives reports whether url has text/template actions in
+// it, i.e. whether renderUrl would interpolate bind parameters into it.
+func hasTemplateDirectives(url string) bool {
+	return strings.Contains(url, "{{")
+}
+
+// renderUrl templates urlTemplate (text/template syntax, e.g.
+// "https://gateway.example.com/{{.tenant}}/proxy") against rawParameters.
+// It uses text/template, not html/template, so every string value is
+// url.PathEscape'd first: a templated Url only ever gets single path
+// segments spliced in, never a "/", "?" or "#" that could steer the
+// rendered RouteServiceURL outside the segment the plan operator intended.
+// Plan.prepare separately refuses a templated Url with no Schema, since the
+// schema is what constrains which parameters a binder may supply at all.
+func renderUrl(urlTemplate string, rawParameters json.RawMessage) (string, error) {
+	if len(rawParameters) == 0 {
+		rawParameters = json.RawMessage("{}")
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(rawParameters, &params); err != nil {
+		return "", &ValidationError{fmt.Errorf("bind parameters must be a JSON object: %s", err)}
+	}
+
+	tmpl, err := template.New("route-service-url").Option("missingkey=error").Parse(urlTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid url template %q: %s", urlTemplate, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, escapeTemplateParams(params)); err != nil {
+		return "", &ValidationError{fmt.Errorf("bind parameters don't satisfy url template %q: %s", urlTemplate, err)}
+	}
+	return rendered.String(), nil
+}
+
+// escapeTemplateParams recursively url.PathEscapes every string leaf in v.
+func escapeTemplateParams(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return url.PathEscape(val)
+	case map[string]interface{}:
+		escaped := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			escaped[k] = escapeTemplateParams(child)
+		}
+		return escaped
+	case []interface{}:
+		escaped := make([]interface{}, len(val))
+		for i, child := range val {
+			escaped[i] = escapeTemplateParams(child)
+		}
+		return escaped
+	default:
+		return val
+	}
+}
+
+// validateBindParameters checks rawParameters against schema, when set. An
+// empty schema accepts anything.
+func validateBindParameters(schema string, rawParameters json.RawMessage) error {
+	if schema == "" {
+		return nil
+	}
+	if len(rawParameters) == 0 {
+		rawParameters = json.RawMessage("{}")
+	}
+	result, err := gojsonschema.Validate(
+		gojsonschema.NewStringLoader(schema),
+		gojsonschema.NewBytesLoader(rawParameters),
+	)
+	if err != nil {
+		return &ValidationError{fmt.Errorf("could not validate bind parameters: %s", err)}
+	}
+	if !result.Valid() {
+		errs := make([]string, 0, len(result.Errors()))
+		for _, e := range result.Errors() {
+			errs = append(errs, e.String())
+		}
+		return &ValidationError{fmt.Errorf("bind parameters are invalid: %v", errs)}
+	}
+	return nil
+}
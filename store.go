@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// InstanceRecord is the metadata persisted for a provisioned service instance.
+type InstanceRecord struct {
+	InstanceID string
+	ServiceID  string
+	PlanID     string
+}
+
+// BindingRecord is the metadata persisted for a route service binding.
+type BindingRecord struct {
+	BindingID       string
+	InstanceID      string
+	ServiceID       string
+	PlanID          string
+	RouteServiceURL string
+}
+
+// Store persists instance and binding metadata so the broker can answer
+// GetInstance/GetBinding/LastOperation. NewRouteSvcStaticBroker takes one as
+// a parameter so a multi-replica deployment can pass a shared backend
+// instead of the default InMemoryStore.
+type Store interface {
+	SaveInstance(record InstanceRecord) error
+	GetInstance(instanceID string) (InstanceRecord, error)
+	DeleteInstance(instanceID string) error
+
+	SaveBinding(record BindingRecord) error
+	GetBinding(instanceID, bindingID string) (BindingRecord, error)
+	DeleteBinding(instanceID, bindingID string) error
+	CountBindings() (int, error)
+}
+
+// ErrNotFound is returned by a Store when the requested instance or binding
+// does not exist.
+var ErrNotFound = fmt.Errorf("not found")
+
+// InMemoryStore is the default Store: a process-local map, not shared across replicas.
+type InMemoryStore struct {
+	mutex     sync.RWMutex
+	instances map[string]InstanceRecord
+	bindings  map[string]BindingRecord
+}
+
+// NewInMemoryStore creates an empty InMemoryStore.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		instances: make(map[string]InstanceRecord),
+		bindings:  make(map[string]BindingRecord),
+	}
+}
+
+func (s *InMemoryStore) SaveInstance(record InstanceRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.instances[record.InstanceID] = record
+	return nil
+}
+
+func (s *InMemoryStore) GetInstance(instanceID string) (InstanceRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	record, ok := s.instances[instanceID]
+	if !ok {
+		return InstanceRecord{}, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *InMemoryStore) DeleteInstance(instanceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.instances, instanceID)
+	return nil
+}
+
+func bindingKey(instanceID, bindingID string) string {
+	return instanceID + "/" + bindingID
+}
+
+func (s *InMemoryStore) SaveBinding(record BindingRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bindings[bindingKey(record.InstanceID, record.BindingID)] = record
+	return nil
+}
+
+func (s *InMemoryStore) GetBinding(instanceID, bindingID string) (BindingRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	record, ok := s.bindings[bindingKey(instanceID, bindingID)]
+	if !ok {
+		return BindingRecord{}, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *InMemoryStore) DeleteBinding(instanceID, bindingID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.bindings, bindingKey(instanceID, bindingID))
+	return nil
+}
+
+func (s *InMemoryStore) CountBindings() (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.bindings), nil
+}